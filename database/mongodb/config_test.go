@@ -0,0 +1,164 @@
+package mongodb
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/mongodb/mongo-go-driver/mongo/options"
+)
+
+func TestConfigFromURIUnixSocket(t *testing.T) {
+	testcases := []struct {
+		name       string
+		dsn        string
+		unixSocket string
+	}{
+		{
+			name:       "socket only",
+			dsn:        "mongodb://%2Ftmp%2Fmongodb-27017.sock/testMigration",
+			unixSocket: "/tmp/mongodb-27017.sock",
+		},
+		{
+			name:       "mixed tcp and socket seed list",
+			dsn:        "mongodb://localhost:27017,%2Ftmp%2Fmongodb-27017.sock/testMigration",
+			unixSocket: "/tmp/mongodb-27017.sock",
+		},
+		{
+			name:       "tcp only",
+			dsn:        "mongodb://localhost:27017/testMigration",
+			unixSocket: "",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			uri, err := url.Parse(tc.dsn)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+
+			config, err := configFromURI(uri)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+
+			if config.UnixSocket != tc.unixSocket {
+				t.Fatalf("expected UnixSocket %q, got %q", tc.unixSocket, config.UnixSocket)
+			}
+		})
+	}
+}
+
+func TestApplyUnixSocketHostsPreservesMixedSeedList(t *testing.T) {
+	dsn := "mongodb://host1:27017,%2Ftmp%2Fmongodb-27017.sock,host2:27018/testMigration"
+	uri, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	config, err := configFromURI(uri)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	clientOpts := options.Client()
+	if err := applyUnixSocketHosts(clientOpts, uri, config); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	want := []string{"host1:27017", "/tmp/mongodb-27017.sock", "host2:27018"}
+	if !reflect.DeepEqual(clientOpts.Hosts, want) {
+		t.Fatalf("expected Hosts %#v, got %#v", want, clientOpts.Hosts)
+	}
+}
+
+func TestApplyUnixSocketHostsNoopWithoutSocket(t *testing.T) {
+	dsn := "mongodb://host1:27017,host2:27018/testMigration"
+	uri, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	config, err := configFromURI(uri)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	clientOpts := options.Client()
+	if err := applyUnixSocketHosts(clientOpts, uri, config); err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if clientOpts.Hosts != nil {
+		t.Fatalf("expected Hosts to be left unset, got %#v", clientOpts.Hosts)
+	}
+}
+
+func TestConfigFromURIAuthMechanism(t *testing.T) {
+	dsn := "mongodb://localhost:27017/testMigration?authMechanism=GSSAPI&authSource=%24external" +
+		"&authMechanismProperties=SERVICE_NAME:mongodb2"
+
+	uri, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	config, err := configFromURI(uri)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if config.AuthMechanism != "GSSAPI" {
+		t.Fatalf("expected AuthMechanism %q, got %q", "GSSAPI", config.AuthMechanism)
+	}
+	if config.AuthSource != "$external" {
+		t.Fatalf("expected AuthSource %q, got %q", "$external", config.AuthSource)
+	}
+
+	wantProps := map[string]string{"SERVICE_NAME": "mongodb2"}
+	if !reflect.DeepEqual(config.AuthMechanismProperties, wantProps) {
+		t.Fatalf("expected AuthMechanismProperties %#v, got %#v", wantProps, config.AuthMechanismProperties)
+	}
+}
+
+func TestCredentialFromConfigAuthMechanism(t *testing.T) {
+	config := &Config{
+		AuthMechanism:           "GSSAPI",
+		AuthSource:              "$external",
+		AuthMechanismProperties: map[string]string{"SERVICE_NAME": "mongodb2"},
+	}
+
+	cred, ok, err := credentialFromConfig(config)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !ok {
+		t.Fatalf("expected credentialFromConfig to report a credential")
+	}
+	if cred.AuthMechanism != "GSSAPI" || cred.AuthSource != "$external" {
+		t.Fatalf("expected AuthMechanism/AuthSource to pass through, got %#v", cred)
+	}
+	if cred.AuthMechanismProperties["SERVICE_NAME"] != "mongodb2" {
+		t.Fatalf("expected AuthMechanismProperties to pass through, got %#v", cred.AuthMechanismProperties)
+	}
+}
+
+func TestConfigFromURINoAuthMechanism(t *testing.T) {
+	dsn := "mongodb://localhost:27017/testMigration"
+	uri, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	config, err := configFromURI(uri)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+
+	if _, ok, err := credentialFromConfig(config); err != nil {
+		t.Fatalf("%v", err)
+	} else if ok {
+		t.Fatalf("expected no credential when AuthMechanism is unset")
+	}
+}
@@ -0,0 +1,35 @@
+package mongodb
+
+import (
+	"errors"
+	"testing"
+)
+
+type codedError struct {
+	code int
+}
+
+func (e *codedError) Error() string           { return "coded error" }
+func (e *codedError) HasErrorCode(c int) bool { return c == e.code }
+
+func TestIsIndexConflictError(t *testing.T) {
+	testcases := []struct {
+		name     string
+		err      error
+		conflict bool
+	}{
+		{"options conflict code", &codedError{code: errCodeIndexOptionsConflict}, true},
+		{"key specs conflict code", &codedError{code: errCodeIndexKeySpecsConflict}, true},
+		{"unrelated code", &codedError{code: 11000}, false},
+		{"options conflict message", errors.New("IndexOptionsConflict: an index already exists"), true},
+		{"unrelated message", errors.New("connection refused"), false},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isIndexConflictError(tc.err); got != tc.conflict {
+				t.Fatalf("expected %v, got %v", tc.conflict, got)
+			}
+		})
+	}
+}
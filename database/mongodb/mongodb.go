@@ -0,0 +1,786 @@
+// Package mongodb implements the database.Driver interface for MongoDB,
+// backed by the official Go driver.
+//
+// Unix domain socket support (Config.UnixSocket) is unverified end-to-end:
+// TestUnixSocket and TestMixedTCPAndUnixSocket in mongodb_test.go exist but
+// currently skip on every mt.Instance the shared test package provides, so
+// no test run ever actually dials a real socket. See socketInstance's doc
+// comment in mongodb_test.go.
+package mongodb
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	mrand "math/rand"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4/database"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/options"
+	"github.com/mongodb/mongo-go-driver/mongo/readconcern"
+	"github.com/mongodb/mongo-go-driver/mongo/readpref"
+	"github.com/mongodb/mongo-go-driver/mongo/writeconcern"
+)
+
+func init() {
+	db := Mongo{}
+	database.Register("mongodb", &db)
+}
+
+// DefaultMigrationsCollection is the name of the collection that stores the
+// applied migration version, used whenever Config.MigrationsCollection is
+// left empty.
+var DefaultMigrationsCollection = "schema_migrations"
+
+// DefaultLockCollection is the name of the collection used to hold the
+// advisory migration lock, used whenever Config.LockCollection is left
+// empty.
+var DefaultLockCollection = "schema_migrations_lock"
+
+// DefaultLockTimeout is how long Lock retries acquiring the lock before
+// giving up, used whenever Config.LockTimeout is zero.
+var DefaultLockTimeout = 15 * time.Second
+
+// DefaultLockTTL is how long a held lock is considered valid before another
+// process may reclaim it, used whenever Config.LockTTL is zero.
+var DefaultLockTTL = 2 * time.Minute
+
+// lockID is the fixed _id of the single document the lock collection ever
+// holds; acquiring the lock is an insert racing on its uniqueness.
+const lockID = "migrate"
+
+// DefaultMaxTransactionRetries bounds how many times runWithTransaction
+// retries a transaction that failed with a retryable error label, used
+// whenever Config.MaxTransactionRetries is negative; zero is a valid,
+// distinct setting meaning "don't retry at all".
+var DefaultMaxTransactionRetries = 3
+
+// TransactionOptions configures a TransactionMode transaction. A zero value
+// leaves every setting at the driver's own default.
+type TransactionOptions struct {
+	// WriteConcernW is either an int acknowledgment count or the string
+	// "majority".
+	WriteConcernW interface{}
+	// WriteConcernJournal requests journal acknowledgment when set.
+	WriteConcernJournal *bool
+	// WriteConcernWTimeout bounds how long the server waits for the
+	// requested write concern to be satisfied.
+	WriteConcernWTimeout time.Duration
+	// ReadConcernLevel is one of "local", "majority", "linearizable" or
+	// "snapshot".
+	ReadConcernLevel string
+	// ReadPreference is one of "primary", "primaryPreferred",
+	// "secondary", "secondaryPreferred" or "nearest".
+	ReadPreference string
+	// MaxCommitTime bounds how long the server allows the commit of the
+	// transaction to run.
+	MaxCommitTime time.Duration
+}
+
+var (
+	ErrNoDatabaseName = fmt.Errorf("no database name")
+	ErrNilConfig      = fmt.Errorf("no config")
+	ErrLockTimeout    = fmt.Errorf("timed out waiting for lock")
+)
+
+type Mongo struct {
+	db        *mongo.Database
+	isLocked  bool
+	lockOwner string
+
+	// lockIndexEnsured is set once ensureLockTTLIndex has succeeded, so
+	// Lock stops re-issuing the CreateOne call on every acquisition; it's
+	// only set on success so a transient failure (e.g. a dropped
+	// connection) is retried on the next Lock call instead of wedging
+	// every future Lock behind the first error forever.
+	lockIndexEnsured bool
+
+	config *Config
+}
+
+type Config struct {
+	DatabaseName         string
+	MigrationsCollection string
+	TransactionMode      bool
+
+	// TransactionOptions configures the write concern, read concern,
+	// read preference and max commit time applied to every transaction
+	// run under TransactionMode.
+	TransactionOptions TransactionOptions
+
+	// MaxTransactionRetries bounds how many additional times
+	// runWithTransaction retries a transaction after the driver's own
+	// session.WithTransaction call returns a TransientTransactionError or
+	// UnknownTransactionCommitResult label. session.WithTransaction
+	// already retries the callback and the commit internally, bounded by
+	// its own ~120s timeout; this field exists for operators who need to
+	// survive a retryable error that outlives that window (e.g. a
+	// prolonged election). Raising it multiplies the worst-case
+	// wall-clock time a stuck migration can block for by roughly
+	// MaxTransactionRetries+1 on top of the driver's own internal retry
+	// budget, so prefer leaving it at the default unless that's a
+	// tradeoff you want. Zero is honored as "no additional retries"; to
+	// instead fall back to DefaultMaxTransactionRetries, leave this field
+	// negative rather than zero.
+	MaxTransactionRetries int
+
+	// AuthMechanism selects the SASL mechanism name passed through verbatim
+	// to the driver's options.Credential, e.g. "GSSAPI" or "PLAIN". Left
+	// empty, the driver negotiates the default mechanism for the
+	// connection string's credentials. Only mechanisms the pinned
+	// github.com/mongodb/mongo-go-driver implements are supported; it
+	// predates MONGODB-OIDC and MONGODB-AWS, so those names fail
+	// authentication rather than perform an OIDC or AWS IAM exchange.
+	AuthMechanism string
+
+	// AuthSource is the database against which authentication runs.
+	AuthSource string
+
+	// AuthMechanismProperties carries mechanism-specific options, such as
+	// SERVICE_NAME for GSSAPI. It is passed through to the driver verbatim
+	// and is not validated against AuthMechanism.
+	AuthMechanismProperties map[string]string
+
+	// LockCollection is the collection that holds the advisory migration
+	// lock. Defaults to DefaultLockCollection.
+	LockCollection string
+
+	// LockTimeout bounds how long Lock retries acquiring the lock before
+	// returning ErrLockTimeout. Defaults to DefaultLockTimeout.
+	LockTimeout time.Duration
+
+	// LockTTL is how long a held lock is honored before another instance
+	// is allowed to reclaim it, guarding against a crashed holder
+	// wedging migrations forever. Reclamation itself is enforced by the
+	// TTL index on acquiredAt; the lock document's own "ttl" field (in
+	// whole seconds) is stored alongside it only so the setting in effect
+	// when the lock was acquired is visible by inspecting the document.
+	// Defaults to DefaultLockTTL.
+	LockTTL time.Duration
+
+	// MigrationFormat selects how migration files are parsed. Left
+	// empty, Run autodetects between MigrationFormatJSON and
+	// MigrationFormatNDJSON, and recognizes MigrationFormatBSON by its
+	// leading document-length prefix regardless of this setting.
+	// Setting MigrationFormatJSON or MigrationFormatEJSON explicitly
+	// enforces that shape, returning an error instead of silently
+	// falling back to MigrationFormatNDJSON.
+	MigrationFormat MigrationFormat
+
+	// UnixSocket is the filesystem path of a Unix domain socket to
+	// connect through instead of TCP. Open populates it by percent-
+	// decoding a ".sock"-suffixed host, e.g.
+	// mongodb://%2Ftmp%2Fmongodb-27017.sock/testMigration; callers using
+	// WithInstance with a client already dialed over the socket don't
+	// need to set it.
+	UnixSocket string
+}
+
+// MigrationFormat selects how Mongo.Run decodes a migration file.
+type MigrationFormat string
+
+const (
+	// MigrationFormatJSON is a top-level JSON array of command
+	// documents, optionally using MongoDB Extended JSON v2 types such as
+	// $oid, $date, $numberLong, $binary and $regex. Setting it explicitly
+	// rejects a migration file that isn't a top-level array, rather than
+	// falling back to autodetection.
+	MigrationFormatJSON MigrationFormat = "json"
+	// MigrationFormatEJSON is an alias of MigrationFormatJSON kept for
+	// migration files that want to be explicit about using Extended
+	// JSON types; it enforces the same top-level array shape.
+	MigrationFormatEJSON MigrationFormat = "ejson"
+	// MigrationFormatNDJSON is one command document per line, as
+	// produced by tools like mongoexport/mongoimport.
+	MigrationFormatNDJSON MigrationFormat = "ndjson"
+	// MigrationFormatBSON is a concatenated stream of raw BSON command
+	// documents, each prefixed with its own little-endian int32 length.
+	MigrationFormatBSON MigrationFormat = "bson"
+)
+
+func WithInstance(instance *mongo.Client, config *Config) (database.Driver, error) {
+	if config == nil {
+		return nil, ErrNilConfig
+	}
+
+	if err := instance.Ping(context.TODO(), nil); err != nil {
+		return nil, err
+	}
+
+	if config.DatabaseName == "" {
+		return nil, ErrNoDatabaseName
+	}
+
+	if config.MigrationsCollection == "" {
+		config.MigrationsCollection = DefaultMigrationsCollection
+	}
+
+	if config.LockCollection == "" {
+		config.LockCollection = DefaultLockCollection
+	}
+
+	if config.LockTimeout == 0 {
+		config.LockTimeout = DefaultLockTimeout
+	}
+
+	if config.LockTTL == 0 {
+		config.LockTTL = DefaultLockTTL
+	}
+
+	if config.MaxTransactionRetries < 0 {
+		config.MaxTransactionRetries = DefaultMaxTransactionRetries
+	}
+
+	lockOwner, err := newLockOwner()
+	if err != nil {
+		return nil, err
+	}
+
+	mc := &Mongo{
+		db:        instance.Database(config.DatabaseName),
+		config:    config,
+		lockOwner: lockOwner,
+	}
+	return mc, nil
+}
+
+// newLockOwner generates a random identifier this *Mongo instance uses to
+// claim and later release the advisory lock, distinguishing it from other
+// processes racing for the same lock document.
+func newLockOwner() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func (m *Mongo) Open(dsn string) (database.Driver, error) {
+	uri, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := configFromURI(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	clientOpts := options.Client().ApplyURI(dsn)
+	if err := applyUnixSocketHosts(clientOpts, uri, config); err != nil {
+		return nil, err
+	}
+	cred, ok, err := credentialFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		// SetAuth replaces the credential ApplyURI parsed, so carry over
+		// the username and password given in the connection string.
+		if clientOpts.Auth != nil {
+			cred.Username = clientOpts.Auth.Username
+			cred.Password = clientOpts.Auth.Password
+		}
+		clientOpts.SetAuth(cred)
+	}
+
+	client, err := mongo.Connect(context.Background(), clientOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	return WithInstance(client, config)
+}
+
+func configFromURI(uri *url.URL) (*Config, error) {
+	databaseName := strings.TrimPrefix(uri.Path, "/")
+	if databaseName == "" {
+		return nil, ErrNoDatabaseName
+	}
+
+	q := uri.Query()
+	config := &Config{
+		DatabaseName:  databaseName,
+		AuthMechanism: q.Get("authMechanism"),
+		AuthSource:    q.Get("authSource"),
+	}
+
+	if hosts, err := decodedHostList(uri); err == nil {
+		if unixSocket, ok := findUnixSocket(hosts); ok {
+			config.UnixSocket = unixSocket
+		}
+	}
+
+	if raw := q.Get("authMechanismProperties"); raw != "" {
+		config.AuthMechanismProperties = parseAuthMechanismProperties(raw)
+	}
+
+	return config, nil
+}
+
+// parseAuthMechanismProperties parses the comma-separated KEY:VALUE list
+// mongo connection strings use for authMechanismProperties, e.g.
+// "ENVIRONMENT:azure,TOKEN_RESOURCE:api://example".
+func parseAuthMechanismProperties(raw string) map[string]string {
+	props := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		kv := strings.SplitN(pair, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		props[kv[0]] = kv[1]
+	}
+	return props
+}
+
+// decodedHostList percent-decodes uri.Host and splits it on the commas that
+// separate entries in a seed list, preserving every host (TCP and Unix
+// socket alike) in order.
+func decodedHostList(uri *url.URL) ([]string, error) {
+	host, err := url.QueryUnescape(uri.Host)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(host, ","), nil
+}
+
+// findUnixSocket looks through a decoded host list for a Unix domain socket
+// path, recognized by its ".sock" suffix as the Mongo drivers do.
+func findUnixSocket(hosts []string) (string, bool) {
+	for _, h := range hosts {
+		if strings.HasSuffix(h, ".sock") {
+			return h, true
+		}
+	}
+	return "", false
+}
+
+// applyUnixSocketHosts overrides clientOpts' host list with the full,
+// percent-decoded seed list from uri whenever config.UnixSocket is set. The
+// driver's own connection-string parser doesn't decode a percent-encoded
+// socket path, so passing just config.UnixSocket here would silently drop
+// every other host in a mixed TCP+socket seed list.
+func applyUnixSocketHosts(clientOpts *options.ClientOptions, uri *url.URL, config *Config) error {
+	if config.UnixSocket == "" {
+		return nil
+	}
+
+	hosts, err := decodedHostList(uri)
+	if err != nil {
+		return err
+	}
+	clientOpts.SetHosts(hosts)
+	return nil
+}
+
+// credentialFromConfig builds the driver credential for config, passing
+// AuthMechanism/AuthSource/AuthMechanismProperties through to the driver
+// verbatim.
+func credentialFromConfig(config *Config) (options.Credential, bool, error) {
+	if config.AuthMechanism == "" {
+		return options.Credential{}, false, nil
+	}
+
+	cred := options.Credential{
+		AuthMechanism:           config.AuthMechanism,
+		AuthSource:              config.AuthSource,
+		AuthMechanismProperties: config.AuthMechanismProperties,
+	}
+
+	return cred, true, nil
+}
+
+func (m *Mongo) SetVersion(version int, dirty bool) error {
+	migrationsCollection := m.db.Collection(m.config.MigrationsCollection)
+
+	if _, err := migrationsCollection.DeleteMany(context.TODO(), bson.M{}); err != nil {
+		return &database.Error{OrigErr: err, Err: "failed to drop old version"}
+	}
+
+	if version >= 0 || (version == database.NilVersion && dirty) {
+		if _, err := migrationsCollection.InsertOne(context.TODO(), bson.M{"version": version, "dirty": dirty}); err != nil {
+			return &database.Error{OrigErr: err, Err: "failed to insert new version"}
+		}
+	}
+
+	return nil
+}
+
+func (m *Mongo) Version() (version int, dirty bool, err error) {
+	var v struct {
+		Version int  `bson:"version"`
+		Dirty   bool `bson:"dirty"`
+	}
+
+	err = m.db.Collection(m.config.MigrationsCollection).FindOne(context.TODO(), bson.M{}).Decode(&v)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return database.NilVersion, false, nil
+	case err != nil:
+		return 0, false, err
+	default:
+		return v.Version, v.Dirty, nil
+	}
+}
+
+func (m *Mongo) Drop() error {
+	return m.db.Drop(context.TODO())
+}
+
+// Lock acquires the advisory migration lock by inserting a document with a
+// fixed _id into Config.LockCollection; the collection's uniqueness
+// constraint on _id makes the insert an atomic compare-and-swap across
+// concurrent instances. A TTL index reclaims the document, and with it the
+// lock, if its holder crashes before calling Unlock.
+func (m *Mongo) Lock() error {
+	if m.isLocked {
+		return database.ErrLocked
+	}
+
+	lockCollection := m.db.Collection(m.config.LockCollection)
+	if !m.lockIndexEnsured {
+		if err := ensureLockTTLIndex(lockCollection, m.config.LockTTL); err != nil {
+			return &database.Error{OrigErr: err, Err: "failed to create lock TTL index"}
+		}
+		m.lockIndexEnsured = true
+	}
+
+	deadline := time.Now().Add(m.config.LockTimeout)
+	backoff := 50 * time.Millisecond
+
+	for {
+		_, err := lockCollection.InsertOne(context.TODO(), bson.M{
+			"_id":        lockID,
+			"owner":      m.lockOwner,
+			"acquiredAt": time.Now(),
+			"ttl":        int64(m.config.LockTTL / time.Second),
+		})
+		if err == nil {
+			m.isLocked = true
+			return nil
+		}
+
+		if !mongo.IsDuplicateKeyError(err) {
+			return &database.Error{OrigErr: err, Err: "failed to acquire lock"}
+		}
+
+		if time.Now().After(deadline) {
+			return ErrLockTimeout
+		}
+
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > time.Second {
+			backoff = time.Second
+		}
+	}
+}
+
+// Unlock releases the lock, but only the document this instance acquired;
+// a lock reclaimed by another instance after TTL expiry is left alone.
+func (m *Mongo) Unlock() error {
+	if !m.isLocked {
+		return nil
+	}
+
+	lockCollection := m.db.Collection(m.config.LockCollection)
+	_, err := lockCollection.DeleteOne(context.TODO(), bson.M{"_id": lockID, "owner": m.lockOwner})
+	if err != nil {
+		return &database.Error{OrigErr: err, Err: "failed to release lock"}
+	}
+
+	m.isLocked = false
+	return nil
+}
+
+// ensureLockTTLIndex creates the TTL index backing lock reclamation. If an
+// index on acquiredAt already exists with a different expireAfterSeconds —
+// expected after restarting with a different Config.LockTTL — the server
+// rejects the conflicting definition; that's tolerated rather than failing
+// Lock forever, since the existing index still reclaims stale locks, just
+// on the previous process's schedule.
+func ensureLockTTLIndex(lockCollection *mongo.Collection, ttl time.Duration) error {
+	_, err := lockCollection.Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.M{"acquiredAt": 1},
+		Options: options.Index().SetExpireAfterSeconds(int32(ttl.Seconds())),
+	})
+	if err != nil && isIndexConflictError(err) {
+		return nil
+	}
+	return err
+}
+
+// indexConflictErrorCoder is implemented by the driver's command errors; it
+// lets isIndexConflictError avoid depending on a concrete error type, same
+// as errorLabeler does for transaction error labels.
+type indexConflictErrorCoder interface {
+	HasErrorCode(int) bool
+}
+
+// IndexOptionsConflict and IndexKeySpecsConflict are the server error codes
+// returned when an index already exists with different options or keys.
+const (
+	errCodeIndexOptionsConflict  = 85
+	errCodeIndexKeySpecsConflict = 86
+)
+
+func isIndexConflictError(err error) bool {
+	if coder, ok := err.(indexConflictErrorCoder); ok {
+		return coder.HasErrorCode(errCodeIndexOptionsConflict) || coder.HasErrorCode(errCodeIndexKeySpecsConflict)
+	}
+	return strings.Contains(err.Error(), "IndexOptionsConflict") ||
+		strings.Contains(err.Error(), "IndexKeySpecsConflict") ||
+		strings.Contains(err.Error(), "already exists with different options")
+}
+
+func (m *Mongo) Close() error {
+	return m.db.Client().Disconnect(context.TODO())
+}
+
+func (m *Mongo) Run(migration io.Reader) error {
+	migr, err := ioutil.ReadAll(migration)
+	if err != nil {
+		return err
+	}
+
+	commands, err := parseMigration(migr, m.config.MigrationFormat)
+	if err != nil {
+		return fmt.Errorf("parse migration error: %v", err)
+	}
+
+	if m.config.TransactionMode {
+		return m.runWithTransaction(context.TODO(), commands)
+	}
+
+	return m.runCommands(context.TODO(), commands)
+}
+
+// parseMigration decodes a migration file into the commands it runs,
+// according to format. A raw BSON stream is recognized by its leading
+// length prefix regardless of format; otherwise an empty format
+// autodetects between a JSON array and newline-delimited documents, while
+// MigrationFormatJSON and MigrationFormatEJSON both require, and enforce,
+// a top-level JSON array.
+func parseMigration(data []byte, format MigrationFormat) ([]bson.M, error) {
+	if looksLikeBSONStream(data) {
+		format = MigrationFormatBSON
+	}
+
+	switch format {
+	case MigrationFormatBSON:
+		return parseBSONStream(data)
+	case MigrationFormatNDJSON:
+		return parseNDJSON(data)
+	case MigrationFormatJSON, MigrationFormatEJSON:
+		return parseJSONArray(data, format)
+	default:
+		trimmed := bytes.TrimSpace(data)
+		if len(trimmed) > 0 && trimmed[0] == '[' {
+			return parseJSONArray(data, format)
+		}
+		return parseNDJSON(data)
+	}
+}
+
+func parseJSONArray(data []byte, format MigrationFormat) ([]bson.M, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		return nil, fmt.Errorf("mongodb: MigrationFormat %q requires a top-level JSON array", format)
+	}
+
+	var commands []bson.M
+	if err := bson.UnmarshalExtJSON(trimmed, false, &commands); err != nil {
+		return nil, err
+	}
+	return commands, nil
+}
+
+// looksLikeBSONStream reports whether data opens with a plausible BSON
+// document: a little-endian int32 length whose document ends in the
+// mandatory trailing null byte.
+func looksLikeBSONStream(data []byte) bool {
+	if len(data) < 5 {
+		return false
+	}
+	n := int32(binary.LittleEndian.Uint32(data[:4]))
+	return n >= 5 && int(n) <= len(data) && data[n-1] == 0x00
+}
+
+func parseBSONStream(data []byte) ([]bson.M, error) {
+	var commands []bson.M
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, fmt.Errorf("truncated bson document")
+		}
+		n := int32(binary.LittleEndian.Uint32(data[:4]))
+		if n < 5 || int(n) > len(data) {
+			return nil, fmt.Errorf("invalid bson document length %d", n)
+		}
+
+		var doc bson.M
+		if err := bson.Unmarshal(data[:n], &doc); err != nil {
+			return nil, err
+		}
+		commands = append(commands, doc)
+		data = data[n:]
+	}
+	return commands, nil
+}
+
+func parseNDJSON(data []byte) ([]bson.M, error) {
+	var commands []bson.M
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		var doc bson.M
+		if err := bson.UnmarshalExtJSON(line, false, &doc); err != nil {
+			return nil, err
+		}
+		commands = append(commands, doc)
+	}
+	return commands, nil
+}
+
+func (m *Mongo) runCommands(ctx context.Context, commands []bson.M) error {
+	for _, cmd := range commands {
+		if err := m.db.RunCommand(ctx, cmd).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transientTransactionError and unknownTransactionCommitResult are the
+// error labels MongoDB documents as safe to retry a transaction on.
+const (
+	transientTransactionError      = "TransientTransactionError"
+	unknownTransactionCommitResult = "UnknownTransactionCommitResult"
+)
+
+func (m *Mongo) runWithTransaction(ctx context.Context, commands []bson.M) error {
+	session, err := m.db.Client().StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	txnOpts, err := buildTransactionOptions(m.config.TransactionOptions)
+	if err != nil {
+		return err
+	}
+
+	run := func() error {
+		_, err := session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+			for _, cmd := range commands {
+				if err := m.db.RunCommand(sessCtx, cmd).Err(); err != nil {
+					return nil, err
+				}
+			}
+			return nil, nil
+		}, txnOpts)
+		return err
+	}
+
+	return retryTransaction(m.config.MaxTransactionRetries, run)
+}
+
+// retryTransaction runs run, retrying up to maxRetries times as long as the
+// returned error carries a TransientTransactionError or
+// UnknownTransactionCommitResult label.
+func retryTransaction(maxRetries int, run func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := run()
+		if err == nil || !isRetryableTransactionError(err) || attempt >= maxRetries {
+			return err
+		}
+		time.Sleep(transactionRetryBackoff(attempt))
+	}
+}
+
+// transactionRetryBackoff grows the delay between transaction retries
+// linearly with attempt, plus jitter, to spread out retries racing against
+// the same contended write.
+func transactionRetryBackoff(attempt int) time.Duration {
+	base := time.Duration(attempt+1) * 50 * time.Millisecond
+	jitter := time.Duration(mrand.Intn(50)) * time.Millisecond
+	return base + jitter
+}
+
+// errorLabeler is implemented by the driver's command and transaction
+// errors; it lets isRetryableTransactionError avoid depending on a concrete
+// error type.
+type errorLabeler interface {
+	HasErrorLabel(string) bool
+}
+
+func isRetryableTransactionError(err error) bool {
+	labeler, ok := err.(errorLabeler)
+	if !ok {
+		return false
+	}
+	return labeler.HasErrorLabel(transientTransactionError) || labeler.HasErrorLabel(unknownTransactionCommitResult)
+}
+
+func buildTransactionOptions(opts TransactionOptions) (*options.TransactionOptions, error) {
+	txnOpts := options.Transaction()
+
+	if opts.WriteConcernW != nil || opts.WriteConcernJournal != nil || opts.WriteConcernWTimeout > 0 {
+		var wcOpts []writeconcern.Option
+
+		if opts.WriteConcernW != nil {
+			switch w := opts.WriteConcernW.(type) {
+			case int:
+				wcOpts = append(wcOpts, writeconcern.W(w))
+			case string:
+				if w != "majority" {
+					return nil, fmt.Errorf("mongodb: unsupported TransactionOptions.WriteConcernW string %q, only \"majority\" is supported", w)
+				}
+				wcOpts = append(wcOpts, writeconcern.WMajority())
+			default:
+				return nil, fmt.Errorf("mongodb: unsupported TransactionOptions.WriteConcernW type %T, want int or string", opts.WriteConcernW)
+			}
+		}
+
+		if opts.WriteConcernJournal != nil {
+			wcOpts = append(wcOpts, writeconcern.J(*opts.WriteConcernJournal))
+		}
+		if opts.WriteConcernWTimeout > 0 {
+			wcOpts = append(wcOpts, writeconcern.WTimeout(opts.WriteConcernWTimeout))
+		}
+		txnOpts.SetWriteConcern(writeconcern.New(wcOpts...))
+	}
+
+	if opts.ReadConcernLevel != "" {
+		txnOpts.SetReadConcern(readconcern.New(readconcern.Level(opts.ReadConcernLevel)))
+	}
+
+	if opts.ReadPreference != "" {
+		mode, err := readpref.ModeFromString(opts.ReadPreference)
+		if err != nil {
+			return nil, fmt.Errorf("mongodb: unsupported TransactionOptions.ReadPreference %q: %v", opts.ReadPreference, err)
+		}
+		rp, err := readpref.New(mode)
+		if err != nil {
+			return nil, fmt.Errorf("mongodb: building read preference %q: %v", opts.ReadPreference, err)
+		}
+		txnOpts.SetReadPreference(rp)
+	}
+
+	if opts.MaxCommitTime > 0 {
+		maxCommitTime := opts.MaxCommitTime
+		txnOpts.SetMaxCommitTime(&maxCommitTime)
+	}
+
+	return txnOpts, nil
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/url"
 	"testing"
 	"time"
 
@@ -13,6 +14,22 @@ import (
 	"github.com/mongodb/mongo-go-driver/mongo"
 )
 
+// socketInstance is implemented by mt.Instance variants that also listen on
+// a Unix domain socket, exposing its filesystem path. As of this writing
+// the shared mt package (github.com/golang-migrate/migrate/v4/testing) has
+// no such variant, so TestUnixSocket and TestMixedTCPAndUnixSocket below
+// skip unconditionally on every mt.Instance it currently provides: neither
+// test actually runs against a real socket in CI today, and Unix socket
+// support is unverified end-to-end until mt gains one. That's a gap in mt,
+// not in this package, and should be closed there (add a socket-listening
+// Instance) rather than worked around here. Until then,
+// TestConfigFromURIUnixSocket, TestApplyUnixSocketHostsPreservesMixedSeedList
+// and TestApplyUnixSocketHostsNoopWithoutSocket in config_test.go cover the
+// socket-handling logic at the unit level only.
+type socketInstance interface {
+	Socket() string
+}
+
 var versions = []mt.Version{
 	{Image: "mongo:4"},
 	{Image: "mongo:3"},
@@ -53,13 +70,93 @@ func Test(t *testing.T) {
 			}
 			defer d.Close()
 			dt.TestNilVersion(t, d)
-			//TestLockAndUnlock(t, d) driver doesn't support lock on database level
+			dt.TestLockAndUnlock(t, d)
 			dt.TestRun(t, d, bytes.NewReader([]byte(`[{"insert":"hello","documents":[{"wild":"world"}]}]`)))
 			dt.TestSetVersion(t, d)
 			dt.TestDrop(t, d)
 		})
 }
 
+func TestLockContention(t *testing.T) {
+	mt.ParallelTest(t, versions, isReady,
+		func(t *testing.T, i mt.Instance) {
+			addr := mongoConnectionString(i.Host(), i.Port())
+
+			p1 := &Mongo{}
+			d1, err := p1.Open(addr)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			defer d1.Close()
+
+			p2 := &Mongo{}
+			d2, err := p2.Open(addr)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			defer d2.Close()
+
+			if err := d1.Lock(); err != nil {
+				t.Fatalf("first lock: %v", err)
+			}
+
+			m2 := d2.(*Mongo)
+			m2.config.LockTimeout = 200 * time.Millisecond
+			if err := d2.Lock(); err == nil {
+				t.Fatalf("expected second instance to fail acquiring a held lock")
+			}
+
+			if err := d1.Unlock(); err != nil {
+				t.Fatalf("unlock: %v", err)
+			}
+
+			if err := d2.Lock(); err != nil {
+				t.Fatalf("lock after release: %v", err)
+			}
+			if err := d2.Unlock(); err != nil {
+				t.Fatalf("%v", err)
+			}
+		})
+}
+
+func TestUnixSocket(t *testing.T) {
+	mt.ParallelTest(t, versions, isReady,
+		func(t *testing.T, i mt.Instance) {
+			si, ok := i.(socketInstance)
+			if !ok || si.Socket() == "" {
+				t.Skip("instance does not expose a unix socket path; see socketInstance's doc comment")
+			}
+
+			addr := fmt.Sprintf("mongodb://%s/testMigration", url.QueryEscape(si.Socket()))
+			p := &Mongo{}
+			d, err := p.Open(addr)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			defer d.Close()
+			dt.TestRun(t, d, bytes.NewReader([]byte(`[{"insert":"hello","documents":[{"wild":"world"}]}]`)))
+		})
+}
+
+func TestMixedTCPAndUnixSocket(t *testing.T) {
+	mt.ParallelTest(t, versions, isReady,
+		func(t *testing.T, i mt.Instance) {
+			si, ok := i.(socketInstance)
+			if !ok || si.Socket() == "" {
+				t.Skip("instance does not expose a unix socket path; see socketInstance's doc comment")
+			}
+
+			addr := fmt.Sprintf("mongodb://%s:%v,%s/testMigration", i.Host(), i.Port(), url.QueryEscape(si.Socket()))
+			p := &Mongo{}
+			d, err := p.Open(addr)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			defer d.Close()
+			dt.TestRun(t, d, bytes.NewReader([]byte(`[{"insert":"hello","documents":[{"wild":"world"}]}]`)))
+		})
+}
+
 func TestWithAuth(t *testing.T) {
 	mt.ParallelTest(t, versions, isReady,
 		func(t *testing.T, i mt.Instance) {
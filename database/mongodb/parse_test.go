@@ -0,0 +1,140 @@
+package mongodb
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/primitive"
+)
+
+func TestParseMigration(t *testing.T) {
+	testcases := []struct {
+		name     string
+		format   MigrationFormat
+		data     string
+		expected []bson.M
+	}{
+		{
+			name:     "json array",
+			format:   "",
+			data:     `[{"insert":"hello","documents":[{"wild":"world"}]}]`,
+			expected: []bson.M{{"insert": "hello", "documents": bson.A{bson.M{"wild": "world"}}}},
+		},
+		{
+			name:     "extended json oid",
+			format:   MigrationFormatEJSON,
+			data:     `[{"insert":"hello","documents":[{"_id":{"$oid":"5f1d6b1e4f1a2c0012345678"}}]}]`,
+			expected: []bson.M{{"insert": "hello", "documents": bson.A{bson.M{"_id": objectIDFromHex(t, "5f1d6b1e4f1a2c0012345678")}}}},
+		},
+		{
+			name:     "ndjson",
+			format:   "",
+			data:     "{\"insert\":\"hello\",\"documents\":[{\"wild\":\"west\"}]}\n{\"insert\":\"hello\",\"documents\":[{\"wild\":\"east\"}]}\n",
+			expected: []bson.M{
+				{"insert": "hello", "documents": bson.A{bson.M{"wild": "west"}}},
+				{"insert": "hello", "documents": bson.A{bson.M{"wild": "east"}}},
+			},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			commands, err := parseMigration([]byte(tc.data), tc.format)
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			if !reflect.DeepEqual(commands, tc.expected) {
+				t.Fatalf("expected %#v, got %#v", tc.expected, commands)
+			}
+		})
+	}
+}
+
+func objectIDFromHex(t *testing.T, hex string) interface{} {
+	t.Helper()
+	oid, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return oid
+}
+
+func marshalBSON(t *testing.T, docs ...bson.M) []byte {
+	t.Helper()
+	var buf []byte
+	for _, doc := range docs {
+		b, err := bson.Marshal(doc)
+		if err != nil {
+			t.Fatalf("%v", err)
+		}
+		buf = append(buf, b...)
+	}
+	return buf
+}
+
+func TestLooksLikeBSONStream(t *testing.T) {
+	bsonData := marshalBSON(t, bson.M{"insert": "hello"})
+
+	if !looksLikeBSONStream(bsonData) {
+		t.Fatalf("expected a marshaled bson document to be recognized as a bson stream")
+	}
+	if looksLikeBSONStream([]byte(`[{"insert":"hello"}]`)) {
+		t.Fatalf("expected a json array not to be recognized as a bson stream")
+	}
+	if looksLikeBSONStream([]byte{0x05, 0x00, 0x00}) {
+		t.Fatalf("expected data shorter than a length prefix not to be recognized as a bson stream")
+	}
+}
+
+func TestParseMigrationBSON(t *testing.T) {
+	want := []bson.M{
+		{"insert": "hello", "documents": bson.A{bson.M{"wild": "world"}}},
+		{"insert": "hello", "documents": bson.A{bson.M{"wild": "west"}}},
+	}
+	data := marshalBSON(t, want[0], want[1])
+
+	commands, err := parseBSONStream(data)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !reflect.DeepEqual(commands, want) {
+		t.Fatalf("expected %#v, got %#v", want, commands)
+	}
+
+	// Autodetection must pick MigrationFormatBSON from the content alone.
+	commands, err = parseMigration(data, "")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !reflect.DeepEqual(commands, want) {
+		t.Fatalf("expected %#v, got %#v", want, commands)
+	}
+
+	// A raw BSON stream is recognized regardless of an explicit,
+	// mismatched MigrationFormat setting.
+	commands, err = parseMigration(data, MigrationFormatNDJSON)
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if !reflect.DeepEqual(commands, want) {
+		t.Fatalf("expected %#v, got %#v", want, commands)
+	}
+}
+
+func TestParseMigrationExplicitFormatEnforcesShape(t *testing.T) {
+	ndjson := []byte("{\"insert\":\"hello\",\"documents\":[{\"wild\":\"west\"}]}\n")
+
+	for _, format := range []MigrationFormat{MigrationFormatJSON, MigrationFormatEJSON} {
+		if _, err := parseMigration(ndjson, format); err == nil {
+			t.Fatalf("expected %q to reject non-array content instead of falling back to ndjson", format)
+		}
+	}
+}
+
+func TestParseBSONStreamTruncated(t *testing.T) {
+	data := marshalBSON(t, bson.M{"insert": "hello"})
+	if _, err := parseBSONStream(data[:len(data)-2]); err == nil {
+		t.Fatalf("expected an error for a truncated bson document")
+	}
+}
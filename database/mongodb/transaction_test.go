@@ -0,0 +1,99 @@
+package mongodb
+
+import (
+	"errors"
+	"testing"
+)
+
+type labeledError struct {
+	label string
+}
+
+func (e *labeledError) Error() string               { return "labeled error: " + e.label }
+func (e *labeledError) HasErrorLabel(l string) bool { return l == e.label }
+
+// duplicateKeyCommitError is a duplicate key error (code 11000) on a
+// document inserted by the migration's own commands that also carries the
+// UnknownTransactionCommitResult label, so retryTransaction treats it as
+// retryable and runs the transaction again.
+const errCodeDuplicateKey = 11000
+
+type duplicateKeyCommitError struct{}
+
+func (e *duplicateKeyCommitError) Error() string {
+	return "E11000 duplicate key error collection: testMigration.hello"
+}
+func (e *duplicateKeyCommitError) HasErrorLabel(l string) bool {
+	return l == unknownTransactionCommitResult
+}
+func (e *duplicateKeyCommitError) HasErrorCode(code int) bool { return code == errCodeDuplicateKey }
+
+func TestRetryTransactionDuplicateKeyThenSuccess(t *testing.T) {
+	attempts := 0
+	err := retryTransaction(3, func() error {
+		attempts++
+		if attempts == 1 {
+			return &duplicateKeyCommitError{}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected success on second attempt, got %v", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("expected exactly 2 attempts, got %d", attempts)
+	}
+}
+
+func TestRetryTransactionGivesUpOnNonRetryableError(t *testing.T) {
+	wantErr := errors.New("duplicate key error")
+	attempts := 0
+	err := retryTransaction(3, func() error {
+		attempts++
+		return wantErr
+	})
+	if err != wantErr {
+		t.Fatalf("expected %v, got %v", wantErr, err)
+	}
+	if attempts != 1 {
+		t.Fatalf("expected no retries for a non-labeled error, got %d attempts", attempts)
+	}
+}
+
+func TestRetryTransactionStopsAtMaxRetries(t *testing.T) {
+	attempts := 0
+	err := retryTransaction(2, func() error {
+		attempts++
+		return &labeledError{label: unknownTransactionCommitResult}
+	})
+	if err == nil {
+		t.Fatalf("expected final attempt to still return an error")
+	}
+	if attempts != 3 {
+		t.Fatalf("expected 1 initial attempt + 2 retries = 3 attempts, got %d", attempts)
+	}
+}
+
+func TestBuildTransactionOptionsRejectsUnsupportedWriteConcernWType(t *testing.T) {
+	_, err := buildTransactionOptions(TransactionOptions{WriteConcernW: int64(1)})
+	if err == nil {
+		t.Fatalf("expected an error for an int64 WriteConcernW instead of silently dropping it")
+	}
+}
+
+func TestBuildTransactionOptionsRejectsUnsupportedWriteConcernWString(t *testing.T) {
+	_, err := buildTransactionOptions(TransactionOptions{WriteConcernW: "1"})
+	if err == nil {
+		t.Fatalf(`expected an error for a WriteConcernW string other than "majority"`)
+	}
+}
+
+func TestBuildTransactionOptionsAcceptsIntWriteConcernW(t *testing.T) {
+	txnOpts, err := buildTransactionOptions(TransactionOptions{WriteConcernW: 1})
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	if txnOpts.WriteConcern == nil {
+		t.Fatalf("expected a write concern to be set")
+	}
+}